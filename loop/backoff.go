@@ -0,0 +1,103 @@
+// Tideland Go Library - Loop - Backoff
+//
+// Copyright (C) 2013-2015 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+package loop
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+//--------------------
+// BACKOFF POLICY
+//--------------------
+
+// BackoffPolicy configures BackoffRecoverFunc. Each retry sleeps for
+// min(Max, Base*Factor^n), where n is the number of retries already
+// made since the last reset. If Jitter is greater than zero the delay
+// is randomized by up to that fraction in either direction around that
+// value (proportional jitter). MaxRetries aborts the loop once
+// exceeded, and ResetAfter clears the retry count once the loop has
+// been running stably for at least that long.
+type BackoffPolicy struct {
+	Base       time.Duration
+	Max        time.Duration
+	Factor     float64
+	Jitter     float64
+	MaxRetries int
+	ResetAfter time.Duration
+}
+
+// BackoffRecoverFunc returns a RecoverFunc that restarts the loop with
+// an exponential backoff between attempts, sleeping via the package's
+// current Clock so tests can verify the cadence without real sleeping.
+func BackoffRecoverFunc(policy BackoffPolicy) RecoverFunc {
+	return func(rs Recoverings) (Recoverings, error) {
+		if policy.ResetAfter > 0 && len(rs) >= 2 {
+			// The gap between two failures includes the backoff sleep
+			// applied before the last run; only the remainder is time
+			// the loop actually spent running.
+			prev := rs[len(rs)-2]
+			last := rs[len(rs)-1].Time
+			if last.Sub(prev.Time)-prev.Sleep >= policy.ResetAfter {
+				rs = Recoverings{rs[len(rs)-1]}
+			}
+		}
+
+		n := len(rs) - 1
+		if policy.MaxRetries > 0 && n >= policy.MaxRetries {
+			return nil, fmt.Errorf("loop: backoff exhausted after %d retries: %v", policy.MaxRetries, rs.Last().Reason)
+		}
+
+		delay := backoffDelay(policy, n)
+		rs[len(rs)-1].Sleep = delay
+		rs[len(rs)-1].NextDelay = backoffDelay(policy, n+1)
+
+		currentClock().Sleep(delay)
+		return rs, nil
+	}
+}
+
+// backoffDelay computes the delay for the n-th retry (0-based).
+func backoffDelay(policy BackoffPolicy, n int) time.Duration {
+	d := float64(policy.Base) * math.Pow(policy.Factor, float64(n))
+	if policy.Max > 0 && d > float64(policy.Max) {
+		d = float64(policy.Max)
+	}
+	if policy.Jitter > 0 {
+		delta := d * policy.Jitter
+		d = d - delta + rand.Float64()*2*delta
+	}
+	if d < 0 {
+		d = 0
+	}
+	return time.Duration(d)
+}
+
+//--------------------
+// CONSTRUCTORS
+//--------------------
+
+// GoRecoverableWithBackoff starts fn and restarts it with the given
+// BackoffPolicy whenever it returns an error or panics.
+func GoRecoverableWithBackoff(fn LoopFunc, policy BackoffPolicy) Loop {
+	return GoRecoverableWithBackoffContext(context.Background(), fn, policy)
+}
+
+// GoRecoverableWithBackoffContext combines GoContext and
+// GoRecoverableWithBackoff.
+func GoRecoverableWithBackoffContext(ctx context.Context, fn LoopFunc, policy BackoffPolicy) Loop {
+	return GoRecoverableContext(ctx, fn, BackoffRecoverFunc(policy))
+}
+
+// EOF
@@ -0,0 +1,115 @@
+// Tideland Go Library - Loop - Unit Test
+//
+// Copyright (C) 2013-2015 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package loop_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tideland/golib/audit"
+	"github.com/tideland/golib/loop"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestBackoffDelays tests that the delays slept between retries follow
+// the configured exponential policy, without any real sleeping.
+func TestBackoffDelays(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	fc := newFakeClock()
+	loop.SetClock(fc)
+	defer loop.SetClock(nil)
+
+	policy := loop.BackoffPolicy{
+		Base:       10 * time.Millisecond,
+		Max:        80 * time.Millisecond,
+		Factor:     2,
+		MaxRetries: 4,
+	}
+
+	count := 0
+	l := loop.GoRecoverableWithBackoff(generateAlwaysErrorBackend(&count), policy)
+
+	assert.ErrorMatch(l.Stop(), ".*backoff exhausted.*")
+	assert.Equal(count, 5, "backend runs once plus four retries")
+
+	sleeps := fc.sleeps()
+	assert.Equal(len(sleeps), 4, "one sleep per retry")
+	assert.Equal(sleeps[0], 10*time.Millisecond)
+	assert.Equal(sleeps[1], 20*time.Millisecond)
+	assert.Equal(sleeps[2], 40*time.Millisecond)
+	assert.Equal(sleeps[3], 80*time.Millisecond, "capped at Max")
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// fakeClock is a deterministic Clock: Sleep never blocks, it just
+// records the requested duration and advances Now() by it.
+type fakeClock struct {
+	mux  sync.Mutex
+	now  time.Time
+	durs []time.Duration
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(0, 0)}
+}
+
+func (fc *fakeClock) Now() time.Time {
+	fc.mux.Lock()
+	defer fc.mux.Unlock()
+	return fc.now
+}
+
+func (fc *fakeClock) Sleep(d time.Duration) {
+	fc.mux.Lock()
+	fc.now = fc.now.Add(d)
+	fc.durs = append(fc.durs, d)
+	fc.mux.Unlock()
+}
+
+// After advances the fake clock by d, like Sleep, but reports back on a
+// channel instead of blocking, so a select driven by it fires instantly.
+func (fc *fakeClock) After(d time.Duration) <-chan time.Time {
+	fc.mux.Lock()
+	fc.now = fc.now.Add(d)
+	fc.durs = append(fc.durs, d)
+	now := fc.now
+	fc.mux.Unlock()
+
+	c := make(chan time.Time, 1)
+	c <- now
+	return c
+}
+
+func (fc *fakeClock) sleeps() []time.Duration {
+	fc.mux.Lock()
+	defer fc.mux.Unlock()
+	out := make([]time.Duration, len(fc.durs))
+	copy(out, fc.durs)
+	return out
+}
+
+func generateAlwaysErrorBackend(count *int) loop.LoopFunc {
+	return func(l loop.Loop) error {
+		*count++
+		return errors.New("nope")
+	}
+}
+
+// EOF
@@ -0,0 +1,72 @@
+// Tideland Go Library - Loop - Clock
+//
+// Copyright (C) 2013-2015 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+package loop
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"sync"
+	"time"
+)
+
+//--------------------
+// CLOCK
+//--------------------
+
+// Clock abstracts the passage of time for the parts of the package that
+// sleep, namely the backoff and the scheduled loop variants. Tests can
+// install a fake Clock via SetClock to verify timing deterministically
+// and without real sleeping.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// Sleep blocks for d.
+	Sleep(d time.Duration)
+
+	// After returns a channel that receives the current time once d has
+	// elapsed, in the style of time.After. Unlike a naive wrapper around
+	// Sleep, an implementation must not need a dedicated goroutine kept
+	// alive to deliver on it, so a caller that abandons the channel (for
+	// example because it stopped waiting) never leaks one.
+	After(d time.Duration) <-chan time.Time
+}
+
+// systemClock is the default Clock backed by the real wall clock.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                        { return time.Now() }
+func (systemClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+var (
+	clockMux  sync.RWMutex
+	clockImpl Clock = systemClock{}
+)
+
+// SetClock installs c as the Clock used package-wide. Passing nil
+// restores the default, real-time Clock. It is meant to be used by
+// tests and should be set before the loops under test are started.
+func SetClock(c Clock) {
+	clockMux.Lock()
+	defer clockMux.Unlock()
+	if c == nil {
+		c = systemClock{}
+	}
+	clockImpl = c
+}
+
+// currentClock returns the Clock currently installed.
+func currentClock() Clock {
+	clockMux.RLock()
+	defer clockMux.RUnlock()
+	return clockImpl
+}
+
+// EOF
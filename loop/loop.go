@@ -0,0 +1,319 @@
+// Tideland Go Library - Loop
+//
+// Copyright (C) 2013-2015 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+package loop
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+//--------------------
+// STATUS
+//--------------------
+
+// Status describes the current status of a loop.
+type Status int
+
+const (
+	Running Status = iota
+	Stopping
+	Stopped
+)
+
+// String implements fmt.Stringer.
+func (s Status) String() string {
+	switch s {
+	case Running:
+		return "running"
+	case Stopping:
+		return "stopping"
+	case Stopped:
+		return "stopped"
+	default:
+		return "unknown"
+	}
+}
+
+//--------------------
+// RECOVERINGS
+//--------------------
+
+// Recovering stores time and reason of one call of a recovery function.
+// Sleep and NextDelay are only populated when the recovery is driven by
+// BackoffRecoverFunc; they record the delay that was applied before this
+// retry and the delay a subsequent retry would use, so a user-provided
+// RecoverFunc composed around it can still inspect or override them.
+type Recovering struct {
+	Time      time.Time
+	Reason    interface{}
+	Sleep     time.Duration
+	NextDelay time.Duration
+}
+
+// Recoverings is a list of recoverings a loop already had to recover.
+type Recoverings []Recovering
+
+// Len returns the number of recoverings.
+func (rs Recoverings) Len() int {
+	return len(rs)
+}
+
+// First returns the first recovering.
+func (rs Recoverings) First() *Recovering {
+	if len(rs) == 0 {
+		return nil
+	}
+	return &rs[0]
+}
+
+// Last returns the last recovering.
+func (rs Recoverings) Last() *Recovering {
+	if len(rs) == 0 {
+		return nil
+	}
+	return &rs[len(rs)-1]
+}
+
+// Trim returns the last n recoverings.
+func (rs Recoverings) Trim(n int) Recoverings {
+	if len(rs) <= n {
+		return rs
+	}
+	return rs[len(rs)-n:]
+}
+
+//--------------------
+// LOOP AND RECOVER FUNCTIONS
+//--------------------
+
+// LoopFunc is managed by a loop to perform the work. It's called with
+// the loop itself to be able to access its ShallStop() channel and to
+// kill it in case of errors.
+type LoopFunc func(l Loop) error
+
+// RecoverFunc is called after a panic or an error returned by a LoopFunc.
+// It receives the recoverings so far and decides if the loop shall be
+// restarted (returning the, possibly trimmed, recoverings and a nil
+// error) or stopped (returning a non-nil error which is then used as
+// the loop's final error).
+type RecoverFunc func(rs Recoverings) (Recoverings, error)
+
+//--------------------
+// LOOP
+//--------------------
+
+// Loop manages a running backend function inside of a goroutine.
+type Loop interface {
+	// Stop tells the loop to stop working and waits until it is done.
+	Stop() error
+
+	// StopWithTimeout tells the loop to stop working and waits until it
+	// is done or the given duration is exceeded. In the latter case the
+	// returned error wraps context.DeadlineExceeded. Unless the loop
+	// already holds an error, the same error is recorded so a later
+	// Error() call observes it too; an error the backend returns after
+	// that does not replace it.
+	StopWithTimeout(d time.Duration) error
+
+	// Kill stops the loop in case of an error, the first error is kept
+	// and later returned by Stop() or Error(). Kill can also be used to
+	// signal a shutdown without an error by passing nil.
+	Kill(err error)
+
+	// Error returns the current status and, if stopped, the error.
+	Error() (Status, error)
+
+	// ShallStop returns a channel that is closed when the loop shall
+	// stop working.
+	ShallStop() <-chan struct{}
+
+	// Context returns a context derived from the one the loop has been
+	// started with. It is canceled whenever Stop(), Kill(), or the
+	// parent context is canceled, so <-l.ShallStop() and
+	// <-l.Context().Done() always fire together. context.Cause(ctx)
+	// returns the reason the loop has been stopped for.
+	Context() context.Context
+}
+
+// loop implements the Loop interface.
+type loop struct {
+	mux         sync.Mutex
+	status      Status
+	err         error
+	loopF       LoopFunc
+	recoverF    RecoverFunc
+	recoverings Recoverings
+	ctx         context.Context
+	cancel      context.CancelCauseFunc
+	stopC       chan struct{}
+	stopOnce    sync.Once
+	cancelOnce  sync.Once
+	doneC       chan struct{}
+}
+
+// Go starts lf in the background and returns its Loop.
+func Go(lf LoopFunc) Loop {
+	return GoContext(context.Background(), lf)
+}
+
+// GoContext starts lf in the background bound to ctx and returns its
+// Loop. The loop is stopped as soon as ctx is done, in addition to the
+// usual Stop()/Kill() control.
+func GoContext(ctx context.Context, lf LoopFunc) Loop {
+	return GoRecoverableContext(ctx, lf, nil)
+}
+
+// GoRecoverable starts lf in the background. In case of an error or a
+// panic rf decides if and how the loop is restarted.
+func GoRecoverable(lf LoopFunc, rf RecoverFunc) Loop {
+	return GoRecoverableContext(context.Background(), lf, rf)
+}
+
+// GoRecoverableContext combines GoContext and GoRecoverable.
+func GoRecoverableContext(ctx context.Context, lf LoopFunc, rf RecoverFunc) Loop {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	lctx, cancel := context.WithCancelCause(ctx)
+	l := &loop{
+		loopF:    lf,
+		recoverF: rf,
+		ctx:      lctx,
+		cancel:   cancel,
+		stopC:    make(chan struct{}),
+		doneC:    make(chan struct{}),
+	}
+	go l.watchContext()
+	go l.run()
+	return l
+}
+
+// watchContext kills the loop as soon as its context is done, so a
+// canceled parent context always stops the loop too.
+func (l *loop) watchContext() {
+	<-l.ctx.Done()
+	l.Kill(context.Cause(l.ctx))
+}
+
+// run is the goroutine controlling the execution and, if configured,
+// the recovering of the backend loop function.
+func (l *loop) run() {
+	defer func() {
+		l.mux.Lock()
+		l.status = Stopped
+		l.mux.Unlock()
+		close(l.doneC)
+	}()
+	var finalErr error
+	for {
+		err := l.callLoopF()
+		if err == nil {
+			break
+		}
+		if l.recoverF == nil {
+			finalErr = err
+			break
+		}
+		l.mux.Lock()
+		l.recoverings = append(l.recoverings, Recovering{Time: time.Now(), Reason: err})
+		rs := l.recoverings
+		l.mux.Unlock()
+		rs, rerr := l.recoverF(rs)
+		l.mux.Lock()
+		l.recoverings = rs
+		l.mux.Unlock()
+		if rerr != nil {
+			finalErr = rerr
+			break
+		}
+	}
+	l.Kill(finalErr)
+}
+
+// callLoopF calls the backend loop function and turns a panic into an
+// error so it can be handled like any other by the recovering.
+func (l *loop) callLoopF() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("loop panic: %v", r)
+		}
+	}()
+	return l.loopF(l)
+}
+
+// Stop implements Loop.
+func (l *loop) Stop() error {
+	l.Kill(nil)
+	<-l.doneC
+	_, err := l.Error()
+	return err
+}
+
+// StopWithTimeout implements Loop.
+func (l *loop) StopWithTimeout(d time.Duration) error {
+	l.Kill(nil)
+	select {
+	case <-l.doneC:
+		_, err := l.Error()
+		return err
+	case <-time.After(d):
+		err := fmt.Errorf("loop: backend did not stop within %s: %w", d, context.DeadlineExceeded)
+		l.mux.Lock()
+		if l.err == nil {
+			l.err = err
+		}
+		l.mux.Unlock()
+		return err
+	}
+}
+
+// Kill implements Loop.
+func (l *loop) Kill(err error) {
+	l.mux.Lock()
+	// context.Canceled is the cause context.CancelCauseFunc records for
+	// a plain Stop()/Kill(nil) as well as for a parent context canceled
+	// without an explicit cause; neither is a real failure, so it must
+	// not overwrite a, so far, nil error once watchContext re-derives it.
+	if err != nil && err != context.Canceled && l.err == nil {
+		l.err = err
+	}
+	if l.status == Running {
+		l.status = Stopping
+	}
+	l.mux.Unlock()
+	l.stopOnce.Do(func() {
+		close(l.stopC)
+	})
+	l.cancelOnce.Do(func() {
+		l.cancel(err)
+	})
+}
+
+// Error implements Loop.
+func (l *loop) Error() (Status, error) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return l.status, l.err
+}
+
+// ShallStop implements Loop.
+func (l *loop) ShallStop() <-chan struct{} {
+	return l.stopC
+}
+
+// Context implements Loop.
+func (l *loop) Context() context.Context {
+	return l.ctx
+}
+
+// EOF
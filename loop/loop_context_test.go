@@ -0,0 +1,115 @@
+// Tideland Go Library - Loop - Unit Test
+//
+// Copyright (C) 2013-2015 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package loop_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tideland/golib/audit"
+	"github.com/tideland/golib/loop"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestGoContextParentCancel tests that canceling the parent context
+// stops the loop and that the cancellation cause is conveyed.
+func TestGoContextParentCancel(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := false
+	l := loop.GoContext(ctx, generateSimpleBackend(&done))
+
+	cancel()
+
+	assert.Nil(l.Stop(), "no error after parent cancel")
+	assert.True(done, "backend has done")
+	assert.True(errors.Is(context.Cause(l.Context()), context.Canceled), "parent's cause is conveyed")
+
+	status, _ := l.Error()
+	assert.Equal(loop.Stopped, status, "loop is stopped")
+}
+
+// TestContextDoneWithShallStop tests that ShallStop() and Context().Done()
+// always fire together.
+func TestContextDoneWithShallStop(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	done := false
+	l := loop.Go(generateSimpleBackend(&done))
+
+	go func() {
+		time.Sleep(shortDelay)
+		l.Kill(errors.New("ouch"))
+	}()
+
+	select {
+	case <-l.ShallStop():
+	case <-time.After(longDelay):
+		t.Fatal("ShallStop() did not fire")
+	}
+	select {
+	case <-l.Context().Done():
+	case <-time.After(longDelay):
+		t.Fatal("Context().Done() did not fire")
+	}
+
+	assert.ErrorMatch(l.Stop(), "ouch", "error has to be 'ouch'")
+	assert.ErrorMatch(context.Cause(l.Context()), "ouch", "cause has to be 'ouch'")
+}
+
+// TestStopWithTimeoutExceeded tests that StopWithTimeout returns a
+// DeadlineExceeded error if the backend doesn't stop in time.
+func TestStopWithTimeoutExceeded(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	done := false
+	l := loop.Go(generateStubbornBackend(&done))
+
+	err := l.StopWithTimeout(shortDelay)
+
+	assert.ErrorMatch(err, ".*did not stop.*")
+	assert.True(errors.Is(err, context.DeadlineExceeded), "error wraps DeadlineExceeded")
+
+	_, statusErr := l.Error()
+	assert.True(errors.Is(statusErr, context.DeadlineExceeded), "Error() observes the same timeout error")
+}
+
+// TestStopWithTimeoutInTime tests that StopWithTimeout returns the
+// regular stop error if the backend stops before the timeout.
+func TestStopWithTimeoutInTime(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	done := false
+	l := loop.Go(generateSimpleBackend(&done))
+
+	assert.Nil(l.StopWithTimeout(longDelay), "no error after stop within timeout")
+	assert.True(done, "backend has done")
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// generateStubbornBackend ignores ShallStop() for longer than the
+// timeouts used in the tests above, so StopWithTimeout() can be
+// verified to actually time out.
+func generateStubbornBackend(done *bool) loop.LoopFunc {
+	return func(l loop.Loop) error {
+		defer func() { t := true; *done = t }()
+		time.Sleep(veryLongDelay)
+		return nil
+	}
+}
+
+// EOF
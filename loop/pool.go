@@ -0,0 +1,245 @@
+// Tideland Go Library - Loop - Pool
+//
+// Copyright (C) 2013-2015 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+package loop
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//--------------------
+// ERRORS
+//--------------------
+
+// ErrPoolFull is returned by Pool.Submit() when the queue is at
+// capacity.
+var ErrPoolFull = errors.New("loop: pool queue is full")
+
+//--------------------
+// JOB
+//--------------------
+
+// Job is the unit of work submitted to a Pool.
+type Job interface{}
+
+//--------------------
+// POOL STATS
+//--------------------
+
+// PoolStats is a snapshot of a Pool's counters.
+type PoolStats struct {
+	Queued    int64
+	InFlight  int64
+	Completed int64
+	Panics    int64
+}
+
+//--------------------
+// POOL OPTIONS
+//--------------------
+
+// PoolOption configures a Pool created by NewPool.
+type PoolOption func(*Pool)
+
+// WithQueueSize sets the capacity of the pool's job queue. It defaults
+// to the pool's worker count.
+func WithQueueSize(n int) PoolOption {
+	return func(p *Pool) {
+		p.queue = make(chan Job, n)
+	}
+}
+
+// WithDrainTimeout makes Stop() wait up to d for queued jobs to be
+// picked up before stopping the workers. Without it Stop() cancels
+// pending jobs immediately.
+func WithDrainTimeout(d time.Duration) PoolOption {
+	return func(p *Pool) {
+		p.drainTimeout = d
+	}
+}
+
+// WithRecoverFunc overrides the RecoverFunc shared by all of the pool's
+// worker loops.
+func WithRecoverFunc(rf RecoverFunc) PoolOption {
+	return func(p *Pool) {
+		p.recoverF = rf
+	}
+}
+
+//--------------------
+// POOL
+//--------------------
+
+// Pool is a bounded worker pool built on top of recoverable loops: a
+// fixed (but resizable) number of workers pull jobs from an internal,
+// bounded queue and run fn for each of them. A panic or error inside fn
+// is contained to the job that caused it and never tears down the pool.
+type Pool struct {
+	fn       func(ctx context.Context, job Job) error
+	recoverF RecoverFunc
+
+	ctx          context.Context
+	cancel       context.CancelFunc
+	queue        chan Job
+	drainTimeout time.Duration
+
+	mux     sync.Mutex
+	workers []Loop
+
+	queuedCount    int64
+	inFlightCount  int64
+	completedCount int64
+	panicCount     int64
+}
+
+// NewPool creates a Pool of size workers running fn.
+func NewPool(size int, fn func(ctx context.Context, job Job) error, opts ...PoolOption) *Pool {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Pool{
+		fn:       fn,
+		recoverF: ignorePoolPanics,
+		ctx:      ctx,
+		cancel:   cancel,
+		queue:    make(chan Job, size),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	p.Resize(size)
+	return p
+}
+
+// ignorePoolPanics is the default RecoverFunc shared by a pool's
+// workers: a crashed worker is simply restarted.
+func ignorePoolPanics(rs Recoverings) (Recoverings, error) {
+	return nil, nil
+}
+
+// Submit queues job without blocking. It returns ErrPoolFull if the
+// queue is at capacity.
+func (p *Pool) Submit(job Job) error {
+	select {
+	case p.queue <- job:
+		atomic.AddInt64(&p.queuedCount, 1)
+		return nil
+	default:
+		return ErrPoolFull
+	}
+}
+
+// SubmitWait queues job, blocking until there is room or ctx is done.
+func (p *Pool) SubmitWait(ctx context.Context, job Job) error {
+	select {
+	case p.queue <- job:
+		atomic.AddInt64(&p.queuedCount, 1)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Resize grows or drains the pool to n workers.
+func (p *Pool) Resize(n int) {
+	if n < 0 {
+		n = 0
+	}
+	p.mux.Lock()
+	cur := len(p.workers)
+	switch {
+	case n > cur:
+		for i := 0; i < n-cur; i++ {
+			p.workers = append(p.workers, p.spawnWorker())
+		}
+	case n < cur:
+		drained := append([]Loop(nil), p.workers[n:]...)
+		p.workers = p.workers[:n]
+		go func() {
+			for i := len(drained) - 1; i >= 0; i-- {
+				drained[i].Stop()
+			}
+		}()
+	}
+	p.mux.Unlock()
+}
+
+// Stats returns a snapshot of the pool's counters.
+func (p *Pool) Stats() PoolStats {
+	return PoolStats{
+		Queued:    atomic.LoadInt64(&p.queuedCount),
+		InFlight:  atomic.LoadInt64(&p.inFlightCount),
+		Completed: atomic.LoadInt64(&p.completedCount),
+		Panics:    atomic.LoadInt64(&p.panicCount),
+	}
+}
+
+// Stop stops the pool. If a DrainTimeout has been configured it first
+// waits up to that long for the queue to empty; afterwards every
+// worker is stopped, discarding any job still queued.
+func (p *Pool) Stop() error {
+	if p.drainTimeout > 0 {
+		deadline := time.Now().Add(p.drainTimeout)
+		for len(p.queue) > 0 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	p.mux.Lock()
+	workers := append([]Loop(nil), p.workers...)
+	p.mux.Unlock()
+
+	var firstErr error
+	for _, w := range workers {
+		if err := w.Stop(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	p.cancel()
+	return firstErr
+}
+
+// spawnWorker starts a new recoverable worker loop. Callers must hold
+// p.mux.
+func (p *Pool) spawnWorker() Loop {
+	return GoRecoverableContext(p.ctx, p.worker, p.recoverF)
+}
+
+// worker is the LoopFunc run by every pool worker.
+func (p *Pool) worker(l Loop) error {
+	for {
+		select {
+		case <-l.ShallStop():
+			return nil
+		case job := <-p.queue:
+			atomic.AddInt64(&p.queuedCount, -1)
+			p.processJob(l.Context(), job)
+		}
+	}
+}
+
+// processJob runs fn for job, containing any panic to this single job
+// so the worker keeps serving the queue.
+func (p *Pool) processJob(ctx context.Context, job Job) {
+	atomic.AddInt64(&p.inFlightCount, 1)
+	defer atomic.AddInt64(&p.inFlightCount, -1)
+	defer func() {
+		if r := recover(); r != nil {
+			atomic.AddInt64(&p.panicCount, 1)
+		}
+	}()
+	if err := p.fn(ctx, job); err == nil {
+		atomic.AddInt64(&p.completedCount, 1)
+	}
+}
+
+// EOF
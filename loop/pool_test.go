@@ -0,0 +1,119 @@
+// Tideland Go Library - Loop - Unit Test
+//
+// Copyright (C) 2013-2015 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package loop_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tideland/golib/audit"
+	"github.com/tideland/golib/loop"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestPoolBackpressure tests that Submit() rejects jobs once the queue
+// is full.
+func TestPoolBackpressure(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	release := make(chan struct{})
+	pool := loop.NewPool(1, func(ctx context.Context, job loop.Job) error {
+		<-release
+		return nil
+	}, loop.WithQueueSize(1))
+	defer func() {
+		close(release)
+		pool.Stop()
+	}()
+
+	assert.Nil(pool.Submit("a"), "first job is picked up by the worker")
+	time.Sleep(shortDelay)
+	assert.Nil(pool.Submit("b"), "second job fills the queue")
+	assert.Equal(pool.Submit("c"), loop.ErrPoolFull, "third job is rejected")
+}
+
+// TestPoolSubmitWaitCancel tests that SubmitWait() respects context
+// cancellation while the queue is full.
+func TestPoolSubmitWaitCancel(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	release := make(chan struct{})
+	pool := loop.NewPool(1, func(ctx context.Context, job loop.Job) error {
+		<-release
+		return nil
+	}, loop.WithQueueSize(1))
+	defer func() {
+		close(release)
+		pool.Stop()
+	}()
+
+	assert.Nil(pool.Submit("a"), "first job is picked up by the worker")
+	time.Sleep(shortDelay)
+	assert.Nil(pool.Submit("b"), "second job fills the queue")
+
+	ctx, cancel := context.WithTimeout(context.Background(), shortDelay)
+	defer cancel()
+	assert.Equal(pool.SubmitWait(ctx, "c"), context.DeadlineExceeded)
+}
+
+// TestPoolPanicIsolation tests that a panicking job doesn't stop the
+// worker from processing the following ones.
+func TestPoolPanicIsolation(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	var processed int32
+	pool := loop.NewPool(2, func(ctx context.Context, job loop.Job) error {
+		if job == "boom" {
+			panic("boom")
+		}
+		atomic.AddInt32(&processed, 1)
+		return nil
+	})
+	defer pool.Stop()
+
+	var wg sync.WaitGroup
+	jobs := []loop.Job{"a", "boom", "b", "boom", "c"}
+	for _, j := range jobs {
+		wg.Add(1)
+		go func(j loop.Job) {
+			defer wg.Done()
+			for pool.Submit(j) == loop.ErrPoolFull {
+				time.Sleep(time.Millisecond)
+			}
+		}(j)
+	}
+	wg.Wait()
+	time.Sleep(longDelay)
+
+	assert.Equal(int(atomic.LoadInt32(&processed)), 3, "the three non-panicking jobs were processed")
+	stats := pool.Stats()
+	assert.Equal(stats.Completed, int64(3))
+	assert.Equal(stats.Panics, int64(2))
+}
+
+// TestPoolResize tests that Resize() can grow and drain workers.
+func TestPoolResize(t *testing.T) {
+	pool := loop.NewPool(1, func(ctx context.Context, job loop.Job) error {
+		return nil
+	})
+	defer pool.Stop()
+
+	pool.Resize(4)
+	time.Sleep(shortDelay)
+	pool.Resize(1)
+	time.Sleep(shortDelay)
+}
+
+// EOF
@@ -0,0 +1,286 @@
+// Tideland Go Library - Loop - Schedule
+//
+// Copyright (C) 2013-2015 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+package loop
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//--------------------
+// EVERY
+//--------------------
+
+// GoEvery starts fn every d, without requiring callers to hand-roll the
+// for { select { case <-time.After(d): ... } } idiom themselves.
+func GoEvery(d time.Duration, fn func(l Loop) error) Loop {
+	return GoEveryContext(context.Background(), d, fn)
+}
+
+// GoEveryContext combines GoContext and GoEvery.
+func GoEveryContext(ctx context.Context, d time.Duration, fn func(l Loop) error) Loop {
+	return GoRecoverableContext(ctx, everyLoopFunc(d, fn), nil)
+}
+
+// everyLoopFunc returns the LoopFunc backing GoEvery.
+func everyLoopFunc(d time.Duration, fn func(l Loop) error) LoopFunc {
+	return func(l Loop) error {
+		for {
+			select {
+			case <-l.ShallStop():
+				return nil
+			case <-sleepC(d):
+			}
+			if err := fn(l); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// sleepC returns a channel that fires after d on the package's current
+// Clock, so callers can select on it together with l.ShallStop() without
+// leaking a goroutine that keeps sleeping after the select moves on.
+func sleepC(d time.Duration) <-chan time.Time {
+	return currentClock().After(d)
+}
+
+//--------------------
+// CRON
+//--------------------
+
+// Schedule is a parsed cron spec as returned by ParseCronSchedule.
+type Schedule struct {
+	minute, hour, dom, month, dow, sec map[int]bool
+	domAny, dowAny                     bool
+}
+
+// GoCron starts fn according to spec, a 5- or 6-field cron expression
+// ("minute hour day-of-month month day-of-week [second]") supporting
+// "*", steps ("*/5"), ranges ("1-5") and lists ("1,3,5"). The next fire
+// time is recomputed from the wall clock after every invocation, so
+// fn taking a while to run never causes drift. A spec that parses but
+// can never match any point in time (e.g. day 31 of February) stops the
+// loop with ErrCronNeverFires instead of firing immediately forever.
+func GoCron(spec string, fn func(l Loop) error) (Loop, error) {
+	return GoCronContext(context.Background(), spec, fn)
+}
+
+// GoCronContext combines GoContext and GoCron.
+func GoCronContext(ctx context.Context, spec string, fn func(l Loop) error) (Loop, error) {
+	sched, err := ParseCronSchedule(spec)
+	if err != nil {
+		return nil, err
+	}
+	return GoRecoverableContext(ctx, cronLoopFunc(sched, fn), nil), nil
+}
+
+// ErrCronNeverFires is returned by a GoCron loop whose Schedule can never
+// match any point in time (e.g. "0 0 31 2 *", since February never has a
+// 31st), so the loop stops with a real error instead of busy-looping.
+var ErrCronNeverFires = errors.New("loop: cron schedule never fires")
+
+// cronLoopFunc returns the LoopFunc backing GoCron.
+func cronLoopFunc(sched *Schedule, fn func(l Loop) error) LoopFunc {
+	return func(l Loop) error {
+		for {
+			now := currentClock().Now()
+			next, ok := sched.Next(now)
+			if !ok {
+				return ErrCronNeverFires
+			}
+			select {
+			case <-l.ShallStop():
+				return nil
+			case <-sleepC(next.Sub(now)):
+			}
+			if err := fn(l); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// maxCronSearch bounds how many minutes ahead Next() looks for a match,
+// guarding against specs that can never fire (e.g. day 31 of February).
+const maxCronSearch = 5 * 366 * 24 * 60
+
+// ParseCronSchedule parses spec into a Schedule.
+func ParseCronSchedule(spec string) (*Schedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 && len(fields) != 6 {
+		return nil, fmt.Errorf("loop: invalid cron spec %q: expected 5 or 6 fields, got %d", spec, len(fields))
+	}
+
+	minute, err := parseCronField(fields[0], 0, 59)
+	if err != nil {
+		return nil, err
+	}
+	hour, err := parseCronField(fields[1], 0, 23)
+	if err != nil {
+		return nil, err
+	}
+	dom, err := parseCronField(fields[2], 1, 31)
+	if err != nil {
+		return nil, err
+	}
+	month, err := parseCronField(fields[3], 1, 12)
+	if err != nil {
+		return nil, err
+	}
+	dow, err := parseCronField(fields[4], 0, 6)
+	if err != nil {
+		return nil, err
+	}
+	sec := map[int]bool{0: true}
+	if len(fields) == 6 {
+		sec, err = parseCronField(fields[5], 0, 59)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Schedule{
+		minute: minute,
+		hour:   hour,
+		dom:    dom,
+		month:  month,
+		dow:    dow,
+		sec:    sec,
+		domAny: fields[2] == "*",
+		dowAny: fields[4] == "*",
+	}, nil
+}
+
+// Next returns the first point in time strictly after from at which the
+// Schedule fires, and false if the spec can never match (e.g. day 31 of
+// February), in which case the returned time is the zero time.Time.
+func (s *Schedule) Next(from time.Time) (time.Time, bool) {
+	secs := sortedSeconds(s.sec)
+	t := from.Truncate(time.Minute)
+	for i := 0; i < maxCronSearch; i++ {
+		if s.matches(t) {
+			for _, sec := range secs {
+				if cand := t.Add(time.Duration(sec) * time.Second); cand.After(from) {
+					return cand, true
+				}
+			}
+		}
+		t = t.Add(time.Minute)
+	}
+	return time.Time{}, false
+}
+
+// matches reports whether t's minute, hour, month and day fields are
+// all allowed by the Schedule. Day-of-month and day-of-week are ORed
+// together when both are restricted, following standard cron semantics.
+func (s *Schedule) matches(t time.Time) bool {
+	if !s.minute[t.Minute()] || !s.hour[t.Hour()] || !s.month[int(t.Month())] {
+		return false
+	}
+	domOK := s.dom[t.Day()]
+	dowOK := s.dow[int(t.Weekday())]
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dowOK
+	case s.dowAny:
+		return domOK
+	default:
+		return domOK || dowOK
+	}
+}
+
+// sortedSeconds returns the seconds in set, ascending, so Next can walk
+// through every second a multi-valued seconds field allows rather than
+// only the smallest.
+func sortedSeconds(set map[int]bool) []int {
+	if len(set) == 0 {
+		return []int{0}
+	}
+	secs := make([]int, 0, len(set))
+	for s := range set {
+		secs = append(secs, s)
+	}
+	sort.Ints(secs)
+	return secs
+}
+
+// parseCronField parses a single comma-separated cron field, each part
+// being "*", "*/step", "a-b", "a-b/step" or a plain number.
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	set := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		values, err := parseCronPart(part, min, max)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range values {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+// parseCronPart parses a single "*", "*/step", "a-b", "a-b/step" or
+// plain number part of a cron field.
+func parseCronPart(part string, min, max int) ([]int, error) {
+	step := 1
+	base := part
+	if i := strings.IndexByte(part, '/'); i >= 0 {
+		var err error
+		step, err = strconv.Atoi(part[i+1:])
+		if err != nil || step <= 0 {
+			return nil, fmt.Errorf("loop: invalid cron step %q", part)
+		}
+		base = part[:i]
+	}
+
+	var lo, hi int
+	switch {
+	case base == "*":
+		lo, hi = min, max
+	case strings.Contains(base, "-"):
+		bounds := strings.SplitN(base, "-", 2)
+		var err error
+		lo, err = strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("loop: invalid cron range %q", part)
+		}
+		hi, err = strconv.Atoi(bounds[1])
+		if err != nil {
+			return nil, fmt.Errorf("loop: invalid cron range %q", part)
+		}
+	default:
+		v, err := strconv.Atoi(base)
+		if err != nil {
+			return nil, fmt.Errorf("loop: invalid cron value %q", part)
+		}
+		lo, hi = v, v
+	}
+	if lo < min || hi > max || lo > hi {
+		return nil, fmt.Errorf("loop: cron field %q out of range [%d,%d]", part, min, max)
+	}
+
+	values := make([]int, 0, (hi-lo)/step+1)
+	for v := lo; v <= hi; v += step {
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// EOF
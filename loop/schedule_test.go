@@ -0,0 +1,171 @@
+// Tideland Go Library - Loop - Unit Test
+//
+// Copyright (C) 2013-2015 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package loop_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tideland/golib/audit"
+	"github.com/tideland/golib/loop"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestGoEveryCadence tests that GoEvery fires roughly every d, driven by
+// a fake Clock so no real sleeping is involved.
+func TestGoEveryCadence(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	fc := newFakeClock()
+	loop.SetClock(fc)
+	defer loop.SetClock(nil)
+
+	var fires int32
+	l := loop.GoEvery(time.Minute, func(l loop.Loop) error {
+		atomic.AddInt32(&fires, 1)
+		return nil
+	})
+
+	for atomic.LoadInt32(&fires) < 3 {
+		time.Sleep(time.Millisecond)
+	}
+
+	assert.Nil(l.Stop(), "no error stopping the schedule")
+	for _, d := range fc.sleeps() {
+		assert.Equal(d, time.Minute, "every wait is d")
+	}
+}
+
+// TestParseCronSchedule tests parsing of the supported field syntaxes.
+func TestParseCronSchedule(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+
+	_, err := loop.ParseCronSchedule("*/15 9-17 * * 1-5")
+	assert.Nil(err, "valid 5-field spec parses")
+
+	_, err = loop.ParseCronSchedule("*/15 9-17 * * 1-5 30")
+	assert.Nil(err, "valid 6-field spec with seconds parses")
+
+	_, err = loop.ParseCronSchedule("bogus")
+	assert.NotNil(err, "spec with the wrong number of fields is rejected")
+
+	_, err = loop.ParseCronSchedule("60 * * * *")
+	assert.NotNil(err, "out of range minute is rejected")
+}
+
+// TestCronScheduleNext tests that Next() computes the expected next
+// fire time for a simple, unambiguous spec.
+func TestCronScheduleNext(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+
+	sched, err := loop.ParseCronSchedule("30 4 * * *")
+	assert.Nil(err, "spec parses")
+
+	from := time.Date(2026, 7, 25, 1, 0, 0, 0, time.UTC)
+	next, ok := sched.Next(from)
+	want := time.Date(2026, 7, 25, 4, 30, 0, 0, time.UTC)
+	assert.True(ok, "spec matches")
+	assert.Equal(next, want, "fires at 04:30 the same day")
+
+	from = time.Date(2026, 7, 25, 10, 0, 0, 0, time.UTC)
+	next, ok = sched.Next(from)
+	want = time.Date(2026, 7, 26, 4, 30, 0, 0, time.UTC)
+	assert.True(ok, "spec matches")
+	assert.Equal(next, want, "fires at 04:30 the following day once today's slot has passed")
+}
+
+// TestCronScheduleNextMultipleSeconds tests that a multi-valued seconds
+// field fires at every one of its allowed seconds, not only the first.
+func TestCronScheduleNextMultipleSeconds(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+
+	sched, err := loop.ParseCronSchedule("* * * * * 0,15,30,45")
+	assert.Nil(err, "spec parses")
+
+	from := time.Date(2026, 7, 25, 1, 2, 3, 0, time.UTC)
+	next, ok := sched.Next(from)
+	assert.True(ok, "spec matches")
+	assert.Equal(next, time.Date(2026, 7, 25, 1, 2, 15, 0, time.UTC), "fires at the next allowed second")
+
+	next, ok = sched.Next(next)
+	assert.True(ok, "spec matches")
+	assert.Equal(next, time.Date(2026, 7, 25, 1, 2, 30, 0, time.UTC), "fires at the following allowed second")
+}
+
+// TestCronScheduleNextNeverFires tests that a spec which can never match,
+// such as the 31st day of February, is reported rather than searched
+// forever.
+func TestCronScheduleNextNeverFires(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+
+	sched, err := loop.ParseCronSchedule("0 0 31 2 *")
+	assert.Nil(err, "spec parses")
+
+	_, ok := sched.Next(time.Date(2026, 7, 25, 0, 0, 0, 0, time.UTC))
+	assert.False(ok, "spec can never match")
+}
+
+// TestGoCronCadence tests that GoCron fires at the scheduled times,
+// driven by a fake Clock so no real sleeping is involved.
+func TestGoCronCadence(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	fc := newFakeClock()
+	loop.SetClock(fc)
+	defer loop.SetClock(nil)
+
+	var fires int32
+	l, err := loop.GoCron("* * * * *", func(l loop.Loop) error {
+		atomic.AddInt32(&fires, 1)
+		return nil
+	})
+	assert.Nil(err, "spec parses")
+
+	for atomic.LoadInt32(&fires) < 3 {
+		time.Sleep(time.Millisecond)
+	}
+
+	assert.Nil(l.Stop(), "no error stopping the schedule")
+	for _, d := range fc.sleeps() {
+		assert.Equal(d, time.Minute, "every wait lasts until the next full minute")
+	}
+}
+
+// TestGoCronInvalidSpec tests that GoCron rejects an invalid spec
+// instead of starting a loop.
+func TestGoCronInvalidSpec(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+
+	l, err := loop.GoCron("not a cron spec", func(l loop.Loop) error { return nil })
+
+	assert.NotNil(err, "invalid spec is rejected")
+	assert.Nil(l, "no loop is returned")
+}
+
+// TestGoCronNeverFiresStopsWithError tests that a spec which parses but
+// can never match stops the loop with ErrCronNeverFires instead of
+// busy-looping.
+func TestGoCronNeverFiresStopsWithError(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+
+	l, err := loop.GoCron("0 0 31 2 *", func(l loop.Loop) error { return nil })
+	assert.Nil(err, "spec parses")
+
+	<-l.Context().Done()
+
+	assert.True(errors.Is(l.Stop(), loop.ErrCronNeverFires), "loop stops with ErrCronNeverFires")
+}
+
+// EOF
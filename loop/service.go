@@ -0,0 +1,186 @@
+// Tideland Go Library - Loop - Service
+//
+// Copyright (C) 2013-2015 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+package loop
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"errors"
+	"sync"
+)
+
+//--------------------
+// ERRORS
+//--------------------
+
+var (
+	// ErrAlreadyStarted is returned by ServiceRunner.Start() when the
+	// service is already running.
+	ErrAlreadyStarted = errors.New("loop: service already started")
+
+	// ErrAlreadyStopped is returned by ServiceRunner.Stop() when the
+	// service isn't running.
+	ErrAlreadyStopped = errors.New("loop: service already stopped")
+)
+
+//--------------------
+// SERVICE
+//--------------------
+
+// Service is implemented by user types that want the start/stop
+// lifecycle of a ServiceRunner without dealing with Loop directly.
+// OnStart is called once before OnLoop, which does the actual work the
+// same way a LoopFunc would, e.g. selecting on l.ShallStop(). OnStop is
+// called once OnLoop has returned, be it regularly, with an error, or
+// because the service has been stopped from outside.
+type Service interface {
+	OnStart(l Loop) error
+	OnLoop(l Loop) error
+	OnStop() error
+}
+
+// ServiceRunner drives a Service on top of a Loop, exposing a clean,
+// idempotent start/stop API that plays well with dependency-injection
+// containers and graceful-shutdown sequences.
+type ServiceRunner struct {
+	mux     sync.Mutex
+	svc     Service
+	running bool
+	loop    Loop
+}
+
+// NewService creates a ServiceRunner for svc. The returned runner isn't
+// started yet; call Start() to do so.
+func NewService(svc Service) *ServiceRunner {
+	return &ServiceRunner{svc: svc}
+}
+
+// Start starts the service and waits for OnStart to return. A second
+// call before Stop() returns ErrAlreadyStarted instead of starting a
+// second backend. If OnStart fails its error is returned and the
+// service is left stopped, so Start() may be retried.
+func (r *ServiceRunner) Start() error {
+	r.mux.Lock()
+	if r.running {
+		r.mux.Unlock()
+		return ErrAlreadyStarted
+	}
+	r.running = true
+	startedC := make(chan error, 1)
+	r.loop = Go(r.run(startedC))
+	r.mux.Unlock()
+
+	err := <-startedC
+	if err != nil {
+		r.mux.Lock()
+		r.running = false
+		r.mux.Unlock()
+	}
+	return err
+}
+
+// Stop stops the service and waits for it to be done. A second call
+// after it already stopped returns ErrAlreadyStopped instead of
+// blocking or panicking.
+func (r *ServiceRunner) Stop() error {
+	r.mux.Lock()
+	if !r.running {
+		r.mux.Unlock()
+		return ErrAlreadyStopped
+	}
+	r.running = false
+	l := r.loop
+	r.mux.Unlock()
+	return l.Stop()
+}
+
+// IsRunning reports whether the service has been started and not yet
+// stopped via Stop(). It keeps reporting true after OnLoop has returned
+// on its own, until Stop() is called.
+func (r *ServiceRunner) IsRunning() bool {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.running
+}
+
+// run returns the LoopFunc driving the Service's lifecycle callbacks.
+// It reports the outcome of OnStart on startedC so Start() can return
+// it synchronously, before OnLoop ever runs.
+func (r *ServiceRunner) run(startedC chan<- error) LoopFunc {
+	return func(l Loop) error {
+		err := r.svc.OnStart(l)
+		startedC <- err
+		if err != nil {
+			return err
+		}
+		err = r.svc.OnLoop(l)
+		if serr := r.svc.OnStop(); serr != nil && err == nil {
+			err = serr
+		}
+		return err
+	}
+}
+
+//--------------------
+// MULTI SERVICE
+//--------------------
+
+// MultiService starts a list of services in order and stops them in
+// reverse. If one of them fails to start, the ones already started are
+// rolled back and the start error is returned.
+type MultiService struct {
+	mux      sync.Mutex
+	services []*ServiceRunner
+	started  []*ServiceRunner
+}
+
+// NewMultiService creates a MultiService managing svcs.
+func NewMultiService(svcs ...Service) *MultiService {
+	runners := make([]*ServiceRunner, len(svcs))
+	for i, svc := range svcs {
+		runners[i] = NewService(svc)
+	}
+	return &MultiService{services: runners}
+}
+
+// Start starts all services in order, stopping and forgetting any
+// already started ones as soon as one of them fails to start.
+func (m *MultiService) Start() error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.started = nil
+	for _, r := range m.services {
+		if err := r.Start(); err != nil {
+			for i := len(m.started) - 1; i >= 0; i-- {
+				m.started[i].Stop()
+			}
+			m.started = nil
+			return err
+		}
+		m.started = append(m.started, r)
+	}
+	return nil
+}
+
+// Stop stops all started services in reverse order, returning the first
+// non-nil error while still stopping the rest.
+func (m *MultiService) Stop() error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	var firstErr error
+	for i := len(m.started) - 1; i >= 0; i-- {
+		if err := m.started[i].Stop(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	m.started = nil
+	return firstErr
+}
+
+// EOF
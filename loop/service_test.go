@@ -0,0 +1,109 @@
+// Tideland Go Library - Loop - Unit Test
+//
+// Copyright (C) 2013-2015 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package loop_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/tideland/golib/audit"
+	"github.com/tideland/golib/loop"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestServiceLifecycle tests the regular start/stop cycle and that the
+// lifecycle callbacks are invoked in order.
+func TestServiceLifecycle(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	svc := &testService{}
+	r := loop.NewService(svc)
+
+	assert.False(r.IsRunning(), "not running before Start()")
+	assert.Nil(r.Start(), "no error starting")
+	assert.True(r.IsRunning(), "running after Start()")
+	assert.Nil(r.Stop(), "no error stopping")
+	assert.False(r.IsRunning(), "not running after Stop()")
+
+	assert.Equal(svc.calls, []string{"start", "loop", "stop"}, "callbacks in order")
+}
+
+// TestServiceDoubleStart tests that starting twice returns
+// ErrAlreadyStarted.
+func TestServiceDoubleStart(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	r := loop.NewService(&testService{})
+
+	assert.Nil(r.Start(), "no error starting")
+	assert.Equal(r.Start(), loop.ErrAlreadyStarted, "second start is rejected")
+
+	r.Stop()
+}
+
+// TestServiceDoubleStop tests that stopping twice returns
+// ErrAlreadyStopped.
+func TestServiceDoubleStop(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	r := loop.NewService(&testService{})
+
+	assert.Nil(r.Start(), "no error starting")
+	assert.Nil(r.Stop(), "no error stopping")
+	assert.Equal(r.Stop(), loop.ErrAlreadyStopped, "second stop is rejected")
+}
+
+// TestMultiServiceRollback tests that a failing start rolls back the
+// services already started.
+func TestMultiServiceRollback(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	good := &testService{}
+	bad := &testService{startErr: errors.New("boom")}
+
+	m := loop.NewMultiService(good, bad)
+
+	assert.ErrorMatch(m.Start(), "boom")
+
+	_, err := good.lastLoop.Error()
+	assert.Nil(err, "the started service has been rolled back cleanly")
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// testService is a Service recording the order its callbacks are
+// invoked in.
+type testService struct {
+	calls    []string
+	startErr error
+	lastLoop loop.Loop
+}
+
+func (s *testService) OnStart(l loop.Loop) error {
+	s.lastLoop = l
+	s.calls = append(s.calls, "start")
+	return s.startErr
+}
+
+func (s *testService) OnLoop(l loop.Loop) error {
+	s.calls = append(s.calls, "loop")
+	<-l.ShallStop()
+	return nil
+}
+
+func (s *testService) OnStop() error {
+	s.calls = append(s.calls, "stop")
+	return nil
+}
+
+// EOF
@@ -0,0 +1,358 @@
+// Tideland Go Library - Loop - Supervisor
+//
+// Copyright (C) 2013-2015 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+package loop
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//--------------------
+// STRATEGY
+//--------------------
+
+// Strategy defines how a supervisor reacts on the failure of one of
+// its children, modeled after the Erlang/OTP supervisor behaviour.
+type Strategy int
+
+const (
+	// OneForOne restarts only the child that failed.
+	OneForOne Strategy = iota
+
+	// OneForAll restarts the failed child and all of its siblings.
+	OneForAll
+
+	// RestForOne restarts the failed child and all children that have
+	// been started after it.
+	RestForOne
+)
+
+//--------------------
+// RESTART POLICY
+//--------------------
+
+// RestartPolicy limits how often a child may be restarted. If more than
+// MaxRestarts restarts happen inside the sliding Within window the
+// supervisor gives up on the whole tree and escalates.
+type RestartPolicy struct {
+	MaxRestarts int
+	Within      time.Duration
+}
+
+//--------------------
+// SUPERVISOR
+//--------------------
+
+// Supervisor owns a set of child loops and restarts them according to
+// its Strategy. As a Supervisor is itself a Loop it can be used as a
+// child of another supervisor, allowing trees to be built.
+type Supervisor interface {
+	Loop
+
+	// Go starts fn as a supervised child loop.
+	Go(name string, fn LoopFunc, policy RestartPolicy) Loop
+
+	// GoRecoverable starts fn as a supervised, recoverable child loop.
+	GoRecoverable(name string, fn LoopFunc, rf RecoverFunc, policy RestartPolicy) Loop
+}
+
+// childFailure is sent on a supervisor's eventC whenever one of its
+// children stopped with a non-nil error and wasn't stopped on purpose.
+// inst is stamped with the instance that produced the failure so the
+// monitor can recognize and drop an event that a concurrent restart has
+// already made stale.
+type childFailure struct {
+	child *childSpec
+	inst  *childInstance
+	err   error
+}
+
+// restart records one restart of a childSpec, so an eventual budget
+// escalation can report every reason that contributed to it.
+type restart struct {
+	at  time.Time
+	err error
+}
+
+// aggregateError joins the reasons behind a restart-budget escalation
+// into a single-line message, so it plays well with audit.ErrorMatch's
+// anchored, single-line regular expressions, while still exposing every
+// reason to errors.Is/errors.As via Unwrap.
+type aggregateError struct {
+	reasons []error
+}
+
+// Error implements error.
+func (e *aggregateError) Error() string {
+	msgs := make([]string, len(e.reasons))
+	for i, r := range e.reasons {
+		msgs[i] = r.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Unwrap implements the multi-error interface used by errors.Is/As.
+func (e *aggregateError) Unwrap() []error {
+	return e.reasons
+}
+
+// childSpec is the bookkeeping a supervisor keeps for one child loop.
+type childSpec struct {
+	name     string
+	fn       LoopFunc
+	rf       RecoverFunc
+	policy   RestartPolicy
+	restarts []restart
+
+	mux     sync.Mutex
+	current *childInstance
+}
+
+// childInstance is one run of a childSpec's backing loop. intentional is
+// flipped exactly once, by stop(), and never reset, so the watcher
+// goroutine armed for this very instance can never race a later restart
+// that reuses the flag for a different instance.
+type childInstance struct {
+	loop        Loop
+	intentional int32
+}
+
+// stop tells the current backing loop to stop and waits for it,
+// suppressing the failure event this causes on the supervisor.
+func (cs *childSpec) stop() error {
+	cs.mux.Lock()
+	inst := cs.current
+	cs.mux.Unlock()
+	if inst == nil {
+		return nil
+	}
+
+	atomic.StoreInt32(&inst.intentional, 1)
+	return inst.loop.Stop()
+}
+
+// childHandle is the stable Loop handle returned to callers of Go() and
+// GoRecoverable(); it forwards to whichever backing loop is current,
+// even across restarts.
+type childHandle struct {
+	cs *childSpec
+}
+
+func (ch *childHandle) backing() Loop {
+	ch.cs.mux.Lock()
+	defer ch.cs.mux.Unlock()
+	return ch.cs.current.loop
+}
+
+func (ch *childHandle) Stop() error                           { return ch.backing().Stop() }
+func (ch *childHandle) StopWithTimeout(d time.Duration) error { return ch.backing().StopWithTimeout(d) }
+func (ch *childHandle) Kill(err error)                        { ch.backing().Kill(err) }
+func (ch *childHandle) Error() (Status, error)                { return ch.backing().Error() }
+func (ch *childHandle) ShallStop() <-chan struct{}            { return ch.backing().ShallStop() }
+func (ch *childHandle) Context() context.Context              { return ch.backing().Context() }
+
+// supervisor implements Supervisor on top of a plain Loop that runs the
+// monitor goroutine.
+type supervisor struct {
+	Loop
+
+	strategy Strategy
+
+	mux      sync.Mutex
+	children []*childSpec
+
+	eventC chan childFailure
+}
+
+// GoSupervisor starts a new Supervisor using strategy.
+func GoSupervisor(strategy Strategy) Supervisor {
+	return GoSupervisorContext(context.Background(), strategy)
+}
+
+// GoSupervisorContext starts a new Supervisor bound to ctx.
+func GoSupervisorContext(ctx context.Context, strategy Strategy) Supervisor {
+	s := &supervisor{
+		strategy: strategy,
+		eventC:   make(chan childFailure),
+	}
+	s.Loop = GoContext(ctx, s.monitor)
+	return s
+}
+
+// Go implements Supervisor.
+func (s *supervisor) Go(name string, fn LoopFunc, policy RestartPolicy) Loop {
+	return s.GoRecoverable(name, fn, nil, policy)
+}
+
+// GoRecoverable implements Supervisor.
+func (s *supervisor) GoRecoverable(name string, fn LoopFunc, rf RecoverFunc, policy RestartPolicy) Loop {
+	cs := &childSpec{
+		name:   name,
+		fn:     fn,
+		rf:     rf,
+		policy: policy,
+	}
+	s.mux.Lock()
+	s.children = append(s.children, cs)
+	s.mux.Unlock()
+
+	s.startChild(cs)
+
+	return &childHandle{cs: cs}
+}
+
+// startChild spawns (or respawns) the backing loop of cs and arms the
+// watcher goroutine that reports unexpected terminations.
+func (s *supervisor) startChild(cs *childSpec) {
+	inst := &childInstance{}
+	inst.loop = GoRecoverableContext(s.Context(), cs.fn, cs.rf)
+
+	cs.mux.Lock()
+	cs.current = inst
+	cs.mux.Unlock()
+
+	go func() {
+		<-inst.loop.Context().Done()
+
+		if atomic.LoadInt32(&inst.intentional) == 1 {
+			return
+		}
+
+		_, err := inst.loop.Error()
+		if err == nil {
+			return
+		}
+		s.eventC <- childFailure{child: cs, inst: inst, err: err}
+	}()
+}
+
+// monitor is the supervisor's own loop function: it waits for either
+// its own shutdown signal or a failing child and reacts accordingly.
+func (s *supervisor) monitor(l Loop) error {
+	for {
+		select {
+		case <-l.ShallStop():
+			return s.stopChildren()
+		case ev := <-s.eventC:
+			if err := s.handleFailure(ev); err != nil {
+				s.stopChildren()
+				return err
+			}
+		}
+	}
+}
+
+// handleFailure applies the restart budget and the configured Strategy
+// to a failed child. A non-nil return escalates, killing the whole
+// supervisor.
+func (s *supervisor) handleFailure(ev childFailure) error {
+	cs := ev.child
+	now := currentClock().Now()
+
+	cs.mux.Lock()
+	if cs.current != ev.inst {
+		// A concurrent restart already replaced the instance this event
+		// was reported for, so it no longer reflects the current state
+		// of the child and must not be acted on.
+		cs.mux.Unlock()
+		return nil
+	}
+	cs.restarts = append(cs.restarts, restart{at: now, err: ev.err})
+	cutoff := now.Add(-cs.policy.Within)
+	var kept []restart
+	for _, r := range cs.restarts {
+		if r.at.After(cutoff) {
+			kept = append(kept, r)
+		}
+	}
+	cs.restarts = kept
+	exceeded := cs.policy.MaxRestarts > 0 && len(cs.restarts) > cs.policy.MaxRestarts
+	var reasons []error
+	if exceeded {
+		for _, r := range cs.restarts {
+			reasons = append(reasons, r.err)
+		}
+	}
+	cs.mux.Unlock()
+
+	if exceeded {
+		return fmt.Errorf("loop: supervisor: restart budget exceeded for child %q: %w", cs.name, &aggregateError{reasons: reasons})
+	}
+
+	switch s.strategy {
+	case OneForAll:
+		return s.restartGroup(s.siblingsOf(cs))
+	case RestForOne:
+		return s.restartGroup(s.restOf(cs))
+	default: // OneForOne
+		s.startChild(cs)
+		return nil
+	}
+}
+
+// siblingsOf returns all currently registered children, in start order.
+func (s *supervisor) siblingsOf(_ *childSpec) []*childSpec {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	out := make([]*childSpec, len(s.children))
+	copy(out, s.children)
+	return out
+}
+
+// restOf returns cs and every child started after it, in start order.
+func (s *supervisor) restOf(cs *childSpec) []*childSpec {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for i, c := range s.children {
+		if c == cs {
+			out := make([]*childSpec, len(s.children)-i)
+			copy(out, s.children[i:])
+			return out
+		}
+	}
+	return nil
+}
+
+// restartGroup stops every child in group and restarts them in the same
+// order, which is start order; the child that triggered the failure
+// keeps its original position rather than being restarted last.
+func (s *supervisor) restartGroup(group []*childSpec) error {
+	for _, cs := range group {
+		cs.stop()
+	}
+	for _, cs := range group {
+		s.startChild(cs)
+	}
+	return nil
+}
+
+// stopChildren stops all children in reverse start order, returning the
+// first non-nil error encountered while waiting for all of them.
+func (s *supervisor) stopChildren() error {
+	s.mux.Lock()
+	children := make([]*childSpec, len(s.children))
+	copy(children, s.children)
+	s.mux.Unlock()
+
+	var firstErr error
+	for i := len(children) - 1; i >= 0; i-- {
+		if err := children[i].stop(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// EOF
@@ -0,0 +1,161 @@
+// Tideland Go Library - Loop - Unit Test
+//
+// Copyright (C) 2013-2015 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package loop_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tideland/golib/audit"
+	"github.com/tideland/golib/loop"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestSupervisorOneForOne tests that only the failed child is restarted.
+func TestSupervisorOneForOne(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sup := loop.GoSupervisor(loop.OneForOne)
+	policy := loop.RestartPolicy{MaxRestarts: 5, Within: veryLongDelay}
+
+	var aStarts, bStarts counter
+	sup.Go("a", generateCountingBackend(&aStarts), policy)
+	sup.Go("b", generateFailOnceBackend(&bStarts), policy)
+
+	time.Sleep(longDelay)
+
+	assert.Equal(aStarts.get(), 1, "sibling 'a' must not be restarted")
+	assert.True(bStarts.get() >= 2, "failed child 'b' must be restarted")
+
+	assert.Nil(sup.Stop(), "no error stopping the supervisor")
+}
+
+// TestSupervisorOneForAll tests that all children are restarted when
+// one of them fails.
+func TestSupervisorOneForAll(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sup := loop.GoSupervisor(loop.OneForAll)
+	policy := loop.RestartPolicy{MaxRestarts: 5, Within: veryLongDelay}
+
+	var aStarts, bStarts counter
+	sup.Go("a", generateCountingBackend(&aStarts), policy)
+	sup.Go("b", generateFailOnceBackend(&bStarts), policy)
+
+	time.Sleep(longDelay)
+
+	assert.True(aStarts.get() >= 2, "sibling 'a' must be restarted too")
+	assert.True(bStarts.get() >= 2, "failed child 'b' must be restarted")
+
+	assert.Nil(sup.Stop(), "no error stopping the supervisor")
+}
+
+// TestSupervisorRestartBudgetExceeded tests that a child exceeding its
+// restart budget escalates and kills the supervisor.
+func TestSupervisorRestartBudgetExceeded(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sup := loop.GoSupervisor(loop.OneForOne)
+	policy := loop.RestartPolicy{MaxRestarts: 2, Within: veryLongDelay}
+
+	var starts counter
+	sup.Go("flaky", generateAlwaysFailBackend(&starts), policy)
+
+	<-sup.Context().Done()
+
+	assert.ErrorMatch(sup.Stop(), ".*restart budget exceeded.*")
+}
+
+// TestSupervisorRestartBudgetExceededAggregatesReasons tests that the
+// escalation error reports every failure that contributed to exceeding
+// the budget, not just the one that tipped it over.
+func TestSupervisorRestartBudgetExceededAggregatesReasons(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	sup := loop.GoSupervisor(loop.OneForOne)
+	policy := loop.RestartPolicy{MaxRestarts: 2, Within: veryLongDelay}
+
+	var starts counter
+	sup.Go("flaky", generateDistinctlyFailingBackend(&starts), policy)
+
+	<-sup.Context().Done()
+
+	err := sup.Stop()
+	assert.ErrorMatch(err, ".*restart budget exceeded.*")
+	assert.ErrorMatch(err, ".*fail-0.*")
+	assert.ErrorMatch(err, ".*fail-2.*")
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// counter is a tiny concurrency-safe counter used by the supervisor
+// test backends.
+type counter struct {
+	mux sync.Mutex
+	n   int
+}
+
+func (c *counter) inc() {
+	c.mux.Lock()
+	c.n++
+	c.mux.Unlock()
+}
+
+func (c *counter) get() int {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.n
+}
+
+func generateCountingBackend(starts *counter) loop.LoopFunc {
+	return func(l loop.Loop) error {
+		starts.inc()
+		<-l.ShallStop()
+		return nil
+	}
+}
+
+func generateFailOnceBackend(starts *counter) loop.LoopFunc {
+	return func(l loop.Loop) error {
+		n := starts.get()
+		starts.inc()
+		if n == 0 {
+			return errors.New("first run fails")
+		}
+		<-l.ShallStop()
+		return nil
+	}
+}
+
+func generateAlwaysFailBackend(starts *counter) loop.LoopFunc {
+	return func(l loop.Loop) error {
+		starts.inc()
+		return errors.New("always fails")
+	}
+}
+
+// generateDistinctlyFailingBackend fails every run with a message that
+// identifies which attempt produced it, so a test can confirm an
+// aggregated error reports more than just the last one.
+func generateDistinctlyFailingBackend(starts *counter) loop.LoopFunc {
+	return func(l loop.Loop) error {
+		n := starts.get()
+		starts.inc()
+		return fmt.Errorf("fail-%d", n)
+	}
+}
+
+// EOF